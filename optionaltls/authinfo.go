@@ -0,0 +1,52 @@
+package optionaltls
+
+import "google.golang.org/grpc/credentials"
+
+// AuthInfo is the credentials.AuthInfo implementation returned for every
+// connection accepted by optionaltls. It wraps the credentials.TLSInfo
+// produced by the inner handshake when the connection negotiated TLS, and
+// reports credentials.NoSecurity when the connection was accepted in
+// plaintext mode, so that credentials.CheckSecurityLevel can be used to
+// tell the two cases apart.
+type AuthInfo struct {
+	// TLSInfo is the AuthInfo produced by the wrapped TLS handshake. It is
+	// nil when the connection was accepted in plaintext mode.
+	TLSInfo *credentials.TLSInfo
+
+	common credentials.CommonAuthInfo
+}
+
+// AuthType returns the authentication type of the underlying connection.
+func (a AuthInfo) AuthType() string {
+	if a.TLSInfo != nil {
+		return a.TLSInfo.AuthType()
+	}
+	return "optionaltls-plaintext"
+}
+
+// GetCommonAuthInfo reports the connection's security level, which
+// credentials.CheckSecurityLevel reads, so that it reflects the outcome of
+// the sniffed handshake.
+func (a AuthInfo) GetCommonAuthInfo() credentials.CommonAuthInfo {
+	return a.common
+}
+
+// tlsAuthInfo wraps the AuthInfo produced by a successful TLS handshake,
+// reporting credentials.PrivacyAndIntegrity.
+func tlsAuthInfo(inner credentials.AuthInfo) AuthInfo {
+	info := AuthInfo{
+		common: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}
+	if ti, ok := inner.(credentials.TLSInfo); ok {
+		info.TLSInfo = &ti
+	}
+	return info
+}
+
+// plaintextAuthInfo reports credentials.NoSecurity for a connection that
+// was accepted without negotiating TLS.
+func plaintextAuthInfo() AuthInfo {
+	return AuthInfo{
+		common: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity},
+	}
+}
@@ -0,0 +1,152 @@
+package optionaltls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// FallbackPolicy controls how a client built by NewClient reacts when its
+// preferred TLS handshake does not succeed.
+type FallbackPolicy int
+
+const (
+	// FallbackNever requires TLS to succeed: ClientHandshake returns the
+	// wrapped TLS handshake's error verbatim instead of falling back to
+	// plaintext.
+	FallbackNever FallbackPolicy = iota
+	// FallbackOnHandshakeError falls back to a plaintext connection only
+	// when the peer clearly did not speak TLS, e.g. it closed the
+	// connection or sent non-TLS bytes. Certificate and other handshake
+	// authentication errors are still returned, never silently downgraded.
+	// This is the default.
+	FallbackOnHandshakeError
+	// FallbackAlways skips the TLS handshake outright and always connects
+	// in plaintext, equivalent to WithPreferPlaintext(true).
+	FallbackAlways
+)
+
+// ClientOpt configures a client returned by NewClient.
+type ClientOpt func(*clientOptions)
+
+type clientOptions struct {
+	preferPlaintext bool
+	fallbackPolicy  FallbackPolicy
+}
+
+func newClientOptions(opts ...ClientOpt) *clientOptions {
+	o := &clientOptions{fallbackPolicy: FallbackOnHandshakeError}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithPreferPlaintext makes the client skip the TLS handshake and connect
+// in plaintext outright when prefer is true, for talking to a peer already
+// known not to require TLS.
+func WithPreferPlaintext(prefer bool) ClientOpt {
+	return func(o *clientOptions) {
+		o.preferPlaintext = prefer
+	}
+}
+
+// WithFallbackPolicy sets how the client reacts when its TLS handshake
+// does not succeed. The default is FallbackOnHandshakeError.
+func WithFallbackPolicy(policy FallbackPolicy) ClientOpt {
+	return func(o *clientOptions) {
+		o.fallbackPolicy = policy
+	}
+}
+
+// clientCreds wraps inner client transport credentials so that, depending
+// on its options, a connection may be served in plaintext instead of
+// running inner's TLS handshake.
+type clientCreds struct {
+	inner credentials.TransportCredentials
+	clientOptions
+}
+
+// NewClient returns client transport credentials that attempt a TLS
+// handshake against inner, falling back to a no-op plaintext handshake
+// (reported via a SecurityLevel of credentials.NoSecurity) according to the
+// configured FallbackPolicy. It is the client-side counterpart to New: pass
+// it to grpc.WithTransportCredentials to dial a server that may or may not
+// require TLS. When a FallbackOnHandshakeError client falls back after a
+// failed TLS attempt, conn has already been closed, so the plaintext
+// connection it returns is a fresh one dialed to conn's remote address.
+func NewClient(inner credentials.TransportCredentials, opts ...ClientOpt) credentials.TransportCredentials {
+	return &clientCreds{inner: inner, clientOptions: *newClientOptions(opts...)}
+}
+
+func (c *clientCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if c.preferPlaintext || c.fallbackPolicy == FallbackAlways {
+		return conn, plaintextAuthInfo(), nil
+	}
+
+	remoteAddr := conn.RemoteAddr()
+	tlsConn, authInfo, err := c.inner.ClientHandshake(ctx, authority, conn)
+	if err == nil {
+		return tlsConn, tlsAuthInfo(authInfo), nil
+	}
+	if c.fallbackPolicy == FallbackNever || !isNonTLSPeerError(err) {
+		return nil, nil, err
+	}
+
+	// inner.ClientHandshake (e.g. credentials.tlsCreds) closes conn on any
+	// handshake error before returning it, so conn is already dead here;
+	// redial a fresh connection rather than handing back a closed one.
+	plainConn, dialErr := (&net.Dialer{}).DialContext(ctx, remoteAddr.Network(), remoteAddr.String())
+	if dialErr != nil {
+		return nil, nil, fmt.Errorf("optionaltls: TLS handshake failed (%v) and plaintext fallback dial failed: %w", err, dialErr)
+	}
+	return plainConn, plaintextAuthInfo(), nil
+}
+
+// isNonTLSPeerError reports whether err indicates that the peer could not
+// complete a TLS handshake at the protocol level - for example because it
+// closed the connection outright or sent bytes that are not a TLS record -
+// as opposed to a TLS-level authentication failure such as an untrusted or
+// mismatched certificate. Only the former is safe to retry in plaintext
+// under FallbackOnHandshakeError; certificate and other handshake
+// authentication errors are always propagated, since silently downgrading
+// after a failed certificate check would defeat the point of checking it.
+func isNonTLSPeerError(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	if errors.As(err, &recordHeaderErr) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	// crypto/tls reports TLS alerts, sent or received, as a *net.OpError
+	// with one of these Ops; an alert means the peer does speak TLS.
+	return opErr.Op != "remote error" && opErr.Op != "local error"
+}
+
+func (c *clientCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("optionaltls: client credentials do not support ServerHandshake")
+}
+
+func (c *clientCreds) Info() credentials.ProtocolInfo {
+	return c.inner.Info()
+}
+
+func (c *clientCreds) Clone() credentials.TransportCredentials {
+	clone := *c
+	clone.inner = c.inner.Clone()
+	return &clone
+}
+
+func (c *clientCreds) OverrideServerName(name string) error {
+	return c.inner.OverrideServerName(name)
+}
@@ -0,0 +1,245 @@
+package optionaltls
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// maxPeek bounds how many bytes are peeked from a connection while
+// detecting its protocol before giving up.
+const maxPeek = 4096
+
+// Action is the outcome a ProtocolDetector reaches for a connection once it
+// has seen enough bytes to decide.
+type Action int
+
+const (
+	// ActionTLS routes the connection through the wrapped TLS handshake.
+	ActionTLS Action = iota
+	// ActionPlaintext serves the connection as plaintext gRPC.
+	ActionPlaintext
+	// ActionReject closes the connection without running either handshake.
+	ActionReject
+	// ActionContinue consumes the detector's header off the wire (see
+	// HeaderStripper) and re-runs detection against the remaining bytes,
+	// e.g. after stripping a PROXY protocol header.
+	ActionContinue
+)
+
+// ProtocolDetector inspects the bytes peeked from a freshly accepted
+// connection, without consuming them, and decides how the connection
+// should be handled. Detect may be called repeatedly with a growing peeked
+// slice, bounded by maxPeek, as long as needMore is true; once needMore is
+// false, action is final for that round of detection.
+type ProtocolDetector interface {
+	Detect(peeked []byte) (action Action, needMore bool)
+}
+
+// HeaderStripper is implemented by detectors that return ActionContinue.
+// HeaderLen reports how many of the peeked bytes make up the header and
+// should be discarded from the connection. RemoteAddr, if non-nil,
+// overrides the connection's reported remote address with the real client
+// address found in the header. Both methods are pure functions of the same
+// peeked slice Detect decided on, so a single detector instance can be
+// shared across concurrent connections.
+type HeaderStripper interface {
+	HeaderLen(peeked []byte) int
+	RemoteAddr(peeked []byte) net.Addr
+}
+
+// Responder is implemented by detectors that want to write a response to
+// the connection before it is closed, such as the HTTP/1 detector's 426
+// Upgrade Required reply.
+type Responder interface {
+	Respond(conn net.Conn) error
+}
+
+// detectingCreds wraps inner server transport credentials with an ordered
+// list of ProtocolDetectors used to decide, connection by connection,
+// whether to run the TLS handshake, serve plaintext gRPC, or reject.
+type detectingCreds struct {
+	inner       credentials.TransportCredentials
+	detectors   []ProtocolDetector
+	onHandshake OnHandshakeFunc
+	metrics     Metrics
+}
+
+// NewWithDetectors returns server transport credentials that dispatch each
+// accepted connection to the TLS handshake, plaintext gRPC, or rejection,
+// based on the first detector in detectors that claims the connection
+// (decides an action other than ActionPlaintext). Detectors are evaluated
+// in priority order on each round of peeked bytes, and an earlier detector
+// that still needs more bytes holds up every detector behind it, so e.g.
+// placing ProxyProtocolDetector or HTTP1Detector ahead of a TLS detector
+// lets them recognize their own framing before it is mistaken for
+// plaintext gRPC. New and NewWithDynamicOption are thin wrappers around
+// this constructor using the built-in TLS-ClientHello detector. Use
+// WithOnHandshake and WithMetrics to observe handshake outcomes.
+func NewWithDetectors(inner credentials.TransportCredentials, detectors []ProtocolDetector, opts ...Option) credentials.TransportCredentials {
+	o := newOptions(opts...)
+	return &detectingCreds{inner: inner, detectors: detectors, onHandshake: o.onHandshake, metrics: o.metrics}
+}
+
+// report notifies the installed Metrics and OnHandshakeFunc, if any, of a
+// handshake outcome for the connection whose original remote address was
+// remote.
+func (c *detectingCreds) report(remote net.Addr, outcome Outcome, err error) {
+	if c.metrics != nil {
+		c.metrics.ObserveHandshake(outcome)
+	}
+	if c.onHandshake != nil {
+		c.onHandshake(remote, outcome, err)
+	}
+}
+
+// peekedConn replays the bytes consumed while sniffing the connection back
+// to subsequent readers, and allows a HeaderStripper to override the
+// reported remote address.
+type peekedConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *peekedConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *detectingCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	remote := conn.RemoteAddr()
+	br := bufio.NewReaderSize(conn, maxPeek)
+	pc := &peekedConn{Conn: conn, r: br}
+
+	for {
+		action, winner, peeked, err := detect(br, c.detectors)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch action {
+		case ActionTLS:
+			tlsConn, authInfo, err := c.inner.ServerHandshake(pc)
+			if err != nil {
+				c.report(remote, OutcomeTLS, err)
+				return nil, nil, err
+			}
+			c.report(remote, OutcomeTLS, nil)
+			return tlsConn, tlsAuthInfo(authInfo), nil
+
+		case ActionPlaintext:
+			c.report(remote, OutcomePlaintext, nil)
+			return pc, plaintextAuthInfo(), nil
+
+		case ActionReject:
+			if responder, ok := winner.(Responder); ok {
+				_ = responder.Respond(pc)
+			}
+			pc.Close()
+			outcome := OutcomeRejected
+			if overrider, ok := winner.(outcomeOverrider); ok {
+				outcome = overrider.RejectOutcome()
+			}
+			rejectErr := fmt.Errorf("optionaltls: connection rejected by %T", winner)
+			c.report(remote, outcome, rejectErr)
+			return nil, nil, rejectErr
+
+		case ActionContinue:
+			stripper, ok := winner.(HeaderStripper)
+			if !ok {
+				return nil, nil, fmt.Errorf("optionaltls: detector %T returned ActionContinue without implementing HeaderStripper", winner)
+			}
+			if _, err := br.Discard(stripper.HeaderLen(peeked)); err != nil {
+				return nil, nil, fmt.Errorf("optionaltls: failed to discard protocol header: %w", err)
+			}
+			if addr := stripper.RemoteAddr(peeked); addr != nil {
+				pc.remoteAddr = addr
+			}
+			// Re-run detection against whatever the header wrapped, e.g.
+			// TLS or plaintext gRPC behind a PROXY protocol header.
+
+		default:
+			return nil, nil, fmt.Errorf("optionaltls: detector %T returned unknown action %d", winner, action)
+		}
+	}
+}
+
+// detect peeks a growing window of bytes from br and asks decideRound to
+// pick a winner against each window in turn. It returns the first round's
+// final action, the detector that produced it, and the peeked bytes it
+// decided on.
+func detect(br *bufio.Reader, detectors []ProtocolDetector) (Action, ProtocolDetector, []byte, error) {
+	for n := 1; ; n++ {
+		peeked, peekErr := br.Peek(n)
+		if peekErr != nil && len(peeked) == 0 {
+			return 0, nil, nil, fmt.Errorf("optionaltls: failed to peek connection: %w", peekErr)
+		}
+		// Look at everything already buffered, so a round never waits on
+		// bytes the peer has not sent.
+		if buffered := br.Buffered(); buffered > len(peeked) {
+			peeked, _ = br.Peek(buffered)
+		}
+
+		if action, winner, ok := decideRound(peeked, detectors); ok {
+			return action, winner, peeked, nil
+		}
+
+		if peekErr != nil {
+			return 0, nil, nil, fmt.Errorf("optionaltls: connection closed before its protocol could be detected: %w", peekErr)
+		}
+		if len(peeked) >= maxPeek {
+			return 0, nil, nil, fmt.Errorf("optionaltls: could not detect protocol within %d bytes", maxPeek)
+		}
+		n = len(peeked)
+	}
+}
+
+// decideRound walks detectors in priority order against a single peeked
+// window. ActionPlaintext is the value detectors use to say "this protocol
+// isn't mine", so it never wins over a detector earlier in the list that
+// hasn't made up its mind yet: as soon as any detector still needs more
+// bytes, decideRound reports no decision for this round rather than
+// honoring a later detector's verdict, final or not. Once every detector up
+// to one that claims the connection (any action other than ActionPlaintext)
+// has decided, that claim wins; if all detectors decide ActionPlaintext,
+// the connection is served as plaintext by the last detector in the list.
+func decideRound(peeked []byte, detectors []ProtocolDetector) (Action, ProtocolDetector, bool) {
+	for _, d := range detectors {
+		action, needMore := d.Detect(peeked)
+		if needMore {
+			return 0, nil, false
+		}
+		if action != ActionPlaintext {
+			return action, d, true
+		}
+	}
+	return ActionPlaintext, detectors[len(detectors)-1], true
+}
+
+func (c *detectingCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.inner.ClientHandshake(ctx, authority, conn)
+}
+
+func (c *detectingCreds) Info() credentials.ProtocolInfo {
+	return c.inner.Info()
+}
+
+func (c *detectingCreds) Clone() credentials.TransportCredentials {
+	clone := make([]ProtocolDetector, len(c.detectors))
+	copy(clone, c.detectors)
+	return &detectingCreds{inner: c.inner.Clone(), detectors: clone, onHandshake: c.onHandshake, metrics: c.metrics}
+}
+
+func (c *detectingCreds) OverrideServerName(name string) error {
+	return c.inner.OverrideServerName(name)
+}
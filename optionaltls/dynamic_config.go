@@ -0,0 +1,25 @@
+package optionaltls
+
+import (
+	"crypto/tls"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// NewWithConfigFunc returns server transport credentials that accept both
+// TLS and plaintext connections on the same listener, but resolve the
+// *tls.Config used for each TLS handshake by calling getConfigForClient,
+// installed as tls.Config.GetConfigForClient. This allows certificates,
+// client CAs, and cipher suites to be rotated at runtime (e.g. from
+// SPIFFE/SDS-style rotation or a filesystem watcher) without restarting the
+// server or dropping existing sessions. The plaintext-detection path is
+// unaffected: getConfigForClient is only invoked for connections whose
+// first bytes look like a TLS ClientHello.
+func NewWithConfigFunc(getConfigForClient func(*tls.ClientHelloInfo) (*tls.Config, error), opts ...Option) credentials.TransportCredentials {
+	o := newOptions(opts...)
+	tlsConfig := &tls.Config{
+		ClientAuth:         o.clientAuth,
+		GetConfigForClient: getConfigForClient,
+	}
+	return New(credentials.NewTLS(tlsConfig), opts...)
+}
@@ -0,0 +1,54 @@
+package optionaltls
+
+import "expvar"
+
+// ExpvarMetrics is the built-in Metrics implementation, backed by expvar
+// counters so it costs nothing beyond the standard library. Use
+// NewExpvarMetrics to publish one under a process-unique name; counters are
+// exported individually rather than as a single expvar.Map so each outcome
+// shows up as its own published variable.
+type ExpvarMetrics struct {
+	tls, plaintext, rejected, dynamicDisabled expvar.Int
+}
+
+// NewExpvarMetrics creates an ExpvarMetrics and publishes its counters
+// under "<name>_tls", "<name>_plaintext", "<name>_rejected", and
+// "<name>_dynamic_disabled". name must be unique within the process, per
+// the expvar.Publish contract.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{}
+	expvar.Publish(name+"_tls", &m.tls)
+	expvar.Publish(name+"_plaintext", &m.plaintext)
+	expvar.Publish(name+"_rejected", &m.rejected)
+	expvar.Publish(name+"_dynamic_disabled", &m.dynamicDisabled)
+	return m
+}
+
+// ObserveHandshake implements Metrics.
+func (m *ExpvarMetrics) ObserveHandshake(outcome Outcome) {
+	switch outcome {
+	case OutcomeTLS:
+		m.tls.Add(1)
+	case OutcomePlaintext:
+		m.plaintext.Add(1)
+	case OutcomeRejected:
+		m.rejected.Add(1)
+	case OutcomeDynamicDisabled:
+		m.dynamicDisabled.Add(1)
+	}
+}
+
+// ExpvarCounts is a point-in-time snapshot of an ExpvarMetrics' counters.
+type ExpvarCounts struct {
+	TLS, Plaintext, Rejected, DynamicDisabled int64
+}
+
+// Snapshot returns the current value of each counter.
+func (m *ExpvarMetrics) Snapshot() ExpvarCounts {
+	return ExpvarCounts{
+		TLS:             m.tls.Value(),
+		Plaintext:       m.plaintext.Value(),
+		Rejected:        m.rejected.Value(),
+		DynamicDisabled: m.dynamicDisabled.Value(),
+	}
+}
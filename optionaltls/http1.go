@@ -0,0 +1,61 @@
+package optionaltls
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// maxHTTPRequestLine bounds how many bytes HTTP1Detector will peek while
+// looking for a request line before giving up and treating the connection
+// as plaintext gRPC.
+const maxHTTPRequestLine = maxPeek
+
+// HTTP1Detector recognizes a plain HTTP/1.x request line and rejects the
+// connection with a configurable 426 Upgrade Required response, so that an
+// optionaltls listener behind a load balancer can tell HTTP health checks
+// apart from gRPC traffic.
+type HTTP1Detector struct {
+	response []byte
+}
+
+// NewHTTP1Detector returns a ProtocolDetector that replies to any HTTP/1.x
+// request with a "426 Upgrade Required" response carrying body as its
+// plain-text payload.
+func NewHTTP1Detector(body string) *HTTP1Detector {
+	response := fmt.Sprintf(
+		"HTTP/1.1 426 Upgrade Required\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		len(body), body,
+	)
+	return &HTTP1Detector{response: []byte(response)}
+}
+
+func (d *HTTP1Detector) Detect(peeked []byte) (Action, bool) {
+	idx := bytes.IndexByte(peeked, '\n')
+	if idx < 0 {
+		if len(peeked) >= maxHTTPRequestLine {
+			return ActionPlaintext, false
+		}
+		return ActionPlaintext, true
+	}
+	line := bytes.TrimRight(peeked[:idx], "\r")
+	if !looksLikeHTTPRequestLine(line) {
+		return ActionPlaintext, false
+	}
+	return ActionReject, false
+}
+
+func (d *HTTP1Detector) Respond(conn net.Conn) error {
+	_, err := conn.Write(d.response)
+	return err
+}
+
+// looksLikeHTTPRequestLine reports whether line looks like "METHOD path
+// HTTP/1.x", e.g. "GET / HTTP/1.1".
+func looksLikeHTTPRequestLine(line []byte) bool {
+	fields := bytes.Fields(line)
+	if len(fields) != 3 {
+		return false
+	}
+	return bytes.HasPrefix(fields[2], []byte("HTTP/1."))
+}
@@ -0,0 +1,65 @@
+package optionaltls
+
+import "net"
+
+// Outcome classifies how a connection accepted by optionaltls was handled.
+// It is reported to OnHandshakeFunc and to any Metrics installed via
+// WithMetrics.
+type Outcome int
+
+const (
+	// OutcomeTLS reports a connection that completed the wrapped TLS
+	// handshake.
+	OutcomeTLS Outcome = iota
+	// OutcomePlaintext reports a connection served as plaintext gRPC.
+	OutcomePlaintext
+	// OutcomeRejected reports a connection closed without running either
+	// handshake, e.g. a malformed PROXY protocol header or a plain
+	// HTTP/1.x request.
+	OutcomeRejected
+	// OutcomeDynamicDisabled reports a plaintext connection refused
+	// because a DynamicOptionFunc installed via NewWithDynamicOption
+	// reported false.
+	OutcomeDynamicDisabled
+)
+
+// String returns the label used for this Outcome in Metrics implementations
+// and log output.
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeTLS:
+		return "tls"
+	case OutcomePlaintext:
+		return "plaintext"
+	case OutcomeRejected:
+		return "rejected"
+	case OutcomeDynamicDisabled:
+		return "dynamic_disabled"
+	default:
+		return "unknown"
+	}
+}
+
+// OnHandshakeFunc is called once per accepted connection, after
+// optionaltls has decided — and, for OutcomeTLS, completed — its
+// handshake. remote is the connection's original remote address, even when
+// a ProtocolDetector such as ProxyProtocolDetector later overrides it. err
+// is the handshake or rejection error, if any; it is always nil for
+// OutcomePlaintext.
+type OnHandshakeFunc func(remote net.Addr, outcome Outcome, err error)
+
+// Metrics receives a count of every handshake outcome. Implementations must
+// be safe for concurrent use, since ObserveHandshake is called from every
+// accepted connection's own goroutine. ExpvarMetrics is the built-in,
+// dependency-free implementation; see the optionaltls/prometheusmetrics
+// subpackage for a prometheus.Collector-backed alternative.
+type Metrics interface {
+	ObserveHandshake(outcome Outcome)
+}
+
+// outcomeOverrider is implemented by detectors whose ActionReject should be
+// reported as a more specific Outcome than OutcomeRejected, e.g.
+// defaultDetector reporting OutcomeDynamicDisabled.
+type outcomeOverrider interface {
+	RejectOutcome() Outcome
+}
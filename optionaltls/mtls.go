@@ -0,0 +1,71 @@
+package optionaltls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// Option configures an optionaltls constructor.
+type Option func(*options)
+
+type options struct {
+	clientAuth  tls.ClientAuthType
+	onHandshake OnHandshakeFunc
+	metrics     Metrics
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithClientAuth sets the tls.ClientAuthType used when a connection
+// negotiates TLS. It applies to NewMutualTLS, and to NewWithConfigFunc only
+// when getConfigForClient returns a nil *tls.Config; New,
+// NewWithDynamicOption and NewWithDetectors run the handshake of the
+// credentials they are given and ignore it. The default is tls.NoClientCert,
+// except for NewMutualTLS, which defaults to tls.RequireAndVerifyClientCert.
+func WithClientAuth(authType tls.ClientAuthType) Option {
+	return func(o *options) {
+		o.clientAuth = authType
+	}
+}
+
+// WithOnHandshake installs a callback invoked once per accepted connection
+// with its handshake outcome. See OnHandshakeFunc.
+func WithOnHandshake(f OnHandshakeFunc) Option {
+	return func(o *options) {
+		o.onHandshake = f
+	}
+}
+
+// WithMetrics installs a Metrics that observes every accepted connection's
+// handshake outcome, e.g. an *ExpvarMetrics or a prometheusmetrics.Metrics.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// NewMutualTLS returns server transport credentials that require and verify
+// a client certificate against clientCAs when the connection negotiates
+// TLS, while still accepting plaintext connections on the same listener.
+// The resulting optionaltls.AuthInfo exposes the verified peer chain via
+// its TLSInfo field so authorization interceptors can key off the client
+// identity. Use WithClientAuth to relax the default
+// tls.RequireAndVerifyClientCert requirement.
+func NewMutualTLS(cert tls.Certificate, clientCAs *x509.CertPool, opts ...Option) credentials.TransportCredentials {
+	opts = append([]Option{WithClientAuth(tls.RequireAndVerifyClientCert)}, opts...)
+	o := newOptions(opts...)
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   o.clientAuth,
+	}
+	return New(credentials.NewTLS(tlsConfig), opts...)
+}
@@ -0,0 +1,60 @@
+// Package optionaltls provides gRPC transport credentials that accept both
+// TLS and plaintext connections on the same listener, sniffing the bytes of
+// each accepted connection to decide how it should be handled.
+package optionaltls
+
+import "google.golang.org/grpc/credentials"
+
+// tlsRecordTypeHandshake is the TLS record content type of a ClientHello,
+// used to distinguish a TLS client from a plaintext one by peeking at the
+// first byte written on the wire.
+const tlsRecordTypeHandshake = 0x16
+
+// DynamicOptionFunc reports, at accept time, whether plaintext connections
+// should still be allowed on a listener that otherwise requires TLS.
+type DynamicOptionFunc func() bool
+
+// IsActive reports the current value of the dynamic option.
+func (f DynamicOptionFunc) IsActive() bool {
+	return f()
+}
+
+// defaultDetector is the built-in detector used by New and
+// NewWithDynamicOption: it wraps TLSDetector, optionally rejecting
+// plaintext connections when dynamicOption reports false.
+type defaultDetector struct {
+	dynamicOption DynamicOptionFunc
+}
+
+func (d defaultDetector) Detect(peeked []byte) (Action, bool) {
+	action, needMore := TLSDetector{}.Detect(peeked)
+	if needMore || action == ActionTLS {
+		return action, needMore
+	}
+	if d.dynamicOption != nil && !d.dynamicOption.IsActive() {
+		return ActionReject, false
+	}
+	return action, false
+}
+
+// RejectOutcome implements outcomeOverrider: defaultDetector only ever
+// rejects a connection because the dynamic option turned plaintext off, so
+// that is always the more specific Outcome to report.
+func (d defaultDetector) RejectOutcome() Outcome {
+	return OutcomeDynamicDisabled
+}
+
+// New returns server transport credentials that accept both TLS and
+// plaintext connections on the same listener, running the TLS handshake
+// against inner whenever the client speaks TLS.
+func New(inner credentials.TransportCredentials, opts ...Option) credentials.TransportCredentials {
+	return NewWithDetectors(inner, []ProtocolDetector{defaultDetector{}}, opts...)
+}
+
+// NewWithDynamicOption is like New but allows plaintext connections to be
+// toggled at runtime: when dynamicOption is non-nil and reports false,
+// plaintext connections are rejected while TLS connections continue to be
+// accepted.
+func NewWithDynamicOption(inner credentials.TransportCredentials, dynamicOption DynamicOptionFunc, opts ...Option) credentials.TransportCredentials {
+	return NewWithDetectors(inner, []ProtocolDetector{defaultDetector{dynamicOption: dynamicOption}}, opts...)
+}
@@ -0,0 +1,49 @@
+//go:build optionaltls_prometheus
+
+// Package prometheusmetrics adapts optionaltls.Metrics to a
+// prometheus.Collector. It is kept behind the optionaltls_prometheus build
+// tag so that importing optionaltls does not pull in client_golang for
+// callers who only want the dependency-free optionaltls.ExpvarMetrics;
+// build with "-tags optionaltls_prometheus" to use it.
+package prometheusmetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/eselyavka/go-grpc-optionaltls-creds/optionaltls"
+)
+
+// Metrics is an optionaltls.Metrics implementation that reports handshake
+// counts through a prometheus.CounterVec labeled by outcome, and can be
+// registered with a prometheus.Registerer like any other Collector.
+type Metrics struct {
+	handshakes *prometheus.CounterVec
+}
+
+// New returns a Metrics whose handshake counter is named name, labeled
+// "outcome" with values "tls", "plaintext", "rejected", and
+// "dynamic_disabled". The counter is not registered; register the returned
+// Metrics with a prometheus.Registerer to export it.
+func New(name string) *Metrics {
+	return &Metrics{
+		handshakes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: name,
+			Help: "Count of optionaltls handshakes by outcome.",
+		}, []string{"outcome"}),
+	}
+}
+
+// ObserveHandshake implements optionaltls.Metrics.
+func (m *Metrics) ObserveHandshake(outcome optionaltls.Outcome) {
+	m.handshakes.WithLabelValues(outcome.String()).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.handshakes.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.handshakes.Collect(ch)
+}
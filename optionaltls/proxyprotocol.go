@@ -0,0 +1,166 @@
+package optionaltls
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+)
+
+// proxyV1Prefix is the literal prefix of a PROXY protocol v1 header.
+const proxyV1Prefix = "PROXY "
+
+// proxyV1MaxLen is the maximum length of a v1 header, including the
+// trailing CRLF, per the PROXY protocol specification.
+const proxyV1MaxLen = 107
+
+// proxyV2Signature is the fixed 12-byte signature that opens every PROXY
+// protocol v2 header.
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const proxyV2HeaderPrefixLen = 16
+
+// proxyParseResult is the outcome of attempting to parse a PROXY protocol
+// header out of a peeked byte slice.
+type proxyParseResult int
+
+const (
+	proxyNeedMore proxyParseResult = iota
+	proxyOK
+	proxyMalformed
+)
+
+// ProxyProtocolDetector recognizes the HAProxy PROXY protocol, versions 1
+// and 2, consuming the header and reporting the real client address via
+// HeaderStripper. A connection that goes through this detector is
+// re-dispatched against the whole detector list, this detector included
+// (e.g. reaching a TLS detector placed after it), once the header has been
+// stripped, so a single listener can terminate
+// PROXY-fronted TLS and plaintext gRPC.
+type ProxyProtocolDetector struct{}
+
+// NewProxyProtocolDetector returns a ProtocolDetector for PROXY protocol
+// v1 and v2 headers.
+func NewProxyProtocolDetector() *ProxyProtocolDetector {
+	return &ProxyProtocolDetector{}
+}
+
+func (d *ProxyProtocolDetector) Detect(peeked []byte) (Action, bool) {
+	if bytes.HasPrefix(peeked, proxyV2Signature) {
+		_, _, res := parseProxyV2(peeked)
+		return proxyAction(res)
+	}
+	if len(peeked) >= len(proxyV1Prefix) && bytes.HasPrefix(peeked, []byte(proxyV1Prefix)) {
+		_, _, res := parseProxyV1(peeked)
+		return proxyAction(res)
+	}
+	if len(peeked) < len(proxyV2Signature) {
+		// Not enough bytes yet to rule out the v2 signature.
+		return ActionPlaintext, true
+	}
+	return ActionPlaintext, false
+}
+
+func proxyAction(res proxyParseResult) (Action, bool) {
+	switch res {
+	case proxyNeedMore:
+		return ActionPlaintext, true
+	case proxyMalformed:
+		return ActionReject, false
+	default:
+		return ActionContinue, false
+	}
+}
+
+func (d *ProxyProtocolDetector) HeaderLen(peeked []byte) int {
+	if bytes.HasPrefix(peeked, proxyV2Signature) {
+		n, _, _ := parseProxyV2(peeked)
+		return n
+	}
+	n, _, _ := parseProxyV1(peeked)
+	return n
+}
+
+func (d *ProxyProtocolDetector) RemoteAddr(peeked []byte) net.Addr {
+	if bytes.HasPrefix(peeked, proxyV2Signature) {
+		_, addr, _ := parseProxyV2(peeked)
+		return addr
+	}
+	_, addr, _ := parseProxyV1(peeked)
+	return addr
+}
+
+// parseProxyV1 parses a "PROXY TCP4|TCP6 src dst srcport dstport\r\n" or
+// "PROXY UNKNOWN\r\n" header.
+func parseProxyV1(peeked []byte) (headerLen int, addr net.Addr, res proxyParseResult) {
+	idx := bytes.Index(peeked, []byte("\r\n"))
+	if idx < 0 {
+		if len(peeked) >= proxyV1MaxLen {
+			return 0, nil, proxyMalformed
+		}
+		return 0, nil, proxyNeedMore
+	}
+
+	fields := bytes.Fields(peeked[:idx])
+	if len(fields) < 2 || string(fields[0]) != "PROXY" {
+		return 0, nil, proxyMalformed
+	}
+	if string(fields[1]) == "UNKNOWN" {
+		return idx + 2, nil, proxyOK
+	}
+	if len(fields) != 6 {
+		return 0, nil, proxyMalformed
+	}
+
+	ip := net.ParseIP(string(fields[2]))
+	if ip == nil {
+		return 0, nil, proxyMalformed
+	}
+	port, err := strconv.Atoi(string(fields[4]))
+	if err != nil {
+		return 0, nil, proxyMalformed
+	}
+	return idx + 2, &net.TCPAddr{IP: ip, Port: port}, proxyOK
+}
+
+// parseProxyV2 parses a binary PROXY protocol v2 header.
+func parseProxyV2(peeked []byte) (headerLen int, addr net.Addr, res proxyParseResult) {
+	if len(peeked) < proxyV2HeaderPrefixLen {
+		return 0, nil, proxyNeedMore
+	}
+	verCmd := peeked[12]
+	if verCmd>>4 != 2 {
+		return 0, nil, proxyMalformed
+	}
+	famProto := peeked[13]
+	addrLen := int(peeked[14])<<8 | int(peeked[15])
+	total := proxyV2HeaderPrefixLen + addrLen
+	if len(peeked) < total {
+		return 0, nil, proxyNeedMore
+	}
+
+	cmd := verCmd & 0x0F
+	if cmd == 0x00 {
+		// PROXY command LOCAL: health check with no proxied address.
+		return total, nil, proxyOK
+	}
+
+	body := peeked[proxyV2HeaderPrefixLen:total]
+	switch famProto >> 4 {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return 0, nil, proxyMalformed
+		}
+		srcIP := append(net.IP(nil), body[0:4]...)
+		srcPort := int(body[8])<<8 | int(body[9])
+		return total, &net.TCPAddr{IP: srcIP, Port: srcPort}, proxyOK
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return 0, nil, proxyMalformed
+		}
+		srcIP := append(net.IP(nil), body[0:16]...)
+		srcPort := int(body[32])<<8 | int(body[33])
+		return total, &net.TCPAddr{IP: srcIP, Port: srcPort}, proxyOK
+	default:
+		return total, nil, proxyOK
+	}
+}
@@ -0,0 +1,24 @@
+package optionaltls
+
+// TLSDetector classifies a connection as TLS or plaintext by checking
+// whether its first byte is a TLS handshake record (a ClientHello). It is
+// the detector New and NewWithDynamicOption build on, and can be composed
+// with other detectors via NewWithDetectors, e.g. placed after
+// ProxyProtocolDetector so PROXY-fronted connections are still told apart
+// by whether they speak TLS.
+type TLSDetector struct{}
+
+// NewTLSDetector returns a ProtocolDetector for TLS ClientHellos.
+func NewTLSDetector() *TLSDetector {
+	return &TLSDetector{}
+}
+
+func (TLSDetector) Detect(peeked []byte) (Action, bool) {
+	if len(peeked) < 1 {
+		return ActionPlaintext, true
+	}
+	if peeked[0] == tlsRecordTypeHandshake {
+		return ActionTLS, false
+	}
+	return ActionPlaintext, false
+}
@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/peer"
+
+	"github.com/eselyavka/go-grpc-optionaltls-creds/optionaltls"
+)
+
+func TestClientOptionalTLS(t *testing.T) {
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	srv := createUnstartedServer(optionaltls.New(tc.server))
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	dial := func(t *testing.T, creds credentials.TransportCredentials) credentials.CommonAuthInfo {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatalf("failed to connect to the server %v", err)
+		}
+		defer conn.Close()
+
+		c := pb.NewGreeterClient(conn)
+		var p peer.Peer
+		if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"}, grpc.Peer(&p)); err != nil {
+			t.Fatalf("could not greet: %v", err)
+		}
+		authInfo, ok := p.AuthInfo.(optionaltls.AuthInfo)
+		if !ok {
+			t.Fatalf("unexpected peer AuthInfo type %T", p.AuthInfo)
+		}
+		return authInfo.GetCommonAuthInfo()
+	}
+
+	t.Run("TLS", func(t *testing.T) {
+		client := optionaltls.NewClient(tc.client)
+		if got := dial(t, client).SecurityLevel; got != credentials.PrivacyAndIntegrity {
+			t.Fatalf("got SecurityLevel %v, want PrivacyAndIntegrity", got)
+		}
+	})
+
+	t.Run("PreferPlaintext", func(t *testing.T) {
+		client := optionaltls.NewClient(tc.client, optionaltls.WithPreferPlaintext(true))
+		if got := dial(t, client).SecurityLevel; got != credentials.NoSecurity {
+			t.Fatalf("got SecurityLevel %v, want NoSecurity", got)
+		}
+	})
+}
+
+func TestClientFallbackPolicy(t *testing.T) {
+	// lis accepts connections and closes them without speaking TLS,
+	// standing in for a peer that cannot complete a TLS handshake.
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	dial := func(t *testing.T, policy optionaltls.FallbackPolicy) error {
+		client := optionaltls.NewClient(tc.client, optionaltls.WithFallbackPolicy(policy))
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial %v", err)
+		}
+		defer conn.Close()
+		_, _, err = client.ClientHandshake(ctx, "example.com", conn)
+		return err
+	}
+
+	t.Run("NeverReturnsTheHandshakeError", func(t *testing.T) {
+		if err := dial(t, optionaltls.FallbackNever); err == nil {
+			t.Fatalf("expected the TLS handshake error to propagate")
+		}
+	})
+
+	t.Run("OnHandshakeErrorFallsBackToPlaintext", func(t *testing.T) {
+		if err := dial(t, optionaltls.FallbackOnHandshakeError); err != nil {
+			t.Fatalf("expected a plaintext fallback, got error %v", err)
+		}
+	})
+}
+
+// TestClientFallbackRPC exercises the fallback path end-to-end through
+// grpc.Dial, mirroring TestOptionalTLS: an actual SayHello RPC must
+// complete over the connection the fallback hands back, not just a bare
+// ClientHandshake call.
+func TestClientFallbackRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	// An optionaltls.New server would detect the client's ClientHello and
+	// complete the TLS handshake, so serve plain gRPC directly instead: the
+	// server never speaks TLS and only the client side needs to fall back.
+	srv := createUnstartedServer(insecure.NewCredentials())
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	client := optionaltls.NewClient(tc.client, optionaltls.WithFallbackPolicy(optionaltls.FallbackOnHandshakeError))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(client))
+	if err != nil {
+		t.Fatalf("failed to connect to the server %v", err)
+	}
+	defer conn.Close()
+
+	c := pb.NewGreeterClient(conn)
+	resp, err := c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"})
+	if err != nil {
+		t.Fatalf("could not greet over the plaintext fallback connection: %v", err)
+	}
+	if resp.Message != "Hello noxiouz" {
+		t.Fatalf("unexpected reply %s", resp.Message)
+	}
+}
+
+// TestClientFallbackPropagatesCertError ensures a certificate verification
+// failure is never papered over by FallbackOnHandshakeError: it is a
+// TLS-level authentication error, not evidence the peer isn't speaking TLS.
+func TestClientFallbackPropagatesCertError(t *testing.T) {
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+
+	srv := createUnstartedServer(tc.server)
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	// An empty cert pool can never verify the server's certificate, so the
+	// client's TLS handshake fails with an x509 error, not a connection-level
+	// one.
+	untrusted := credentials.NewClientTLSFromCert(x509.NewCertPool(), "example.com")
+	client := optionaltls.NewClient(untrusted, optionaltls.WithFallbackPolicy(optionaltls.FallbackOnHandshakeError))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial %v", err)
+	}
+	defer conn.Close()
+
+	if _, _, err := client.ClientHandshake(ctx, "example.com", conn); err == nil {
+		t.Fatalf("expected the certificate verification error to propagate instead of falling back")
+	}
+}
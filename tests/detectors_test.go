@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+
+	"github.com/eselyavka/go-grpc-optionaltls-creds/optionaltls"
+)
+
+func TestNewWithDetectorsTLSAndPlaintext(t *testing.T) {
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	srv := createUnstartedServer(optionaltls.NewWithDetectors(tc.server, []optionaltls.ProtocolDetector{optionaltls.NewTLSDetector()}))
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	dial := func(t *testing.T, dialOpt grpc.DialOption) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, dialOpt)
+		if err != nil {
+			t.Fatalf("failed to connect to the server %v", err)
+		}
+		defer conn.Close()
+		c := pb.NewGreeterClient(conn)
+		if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"}); err != nil {
+			t.Fatalf("could not greet: %v", err)
+		}
+	}
+
+	t.Run("Plaintext", func(t *testing.T) { dial(t, grpc.WithInsecure()) })
+	t.Run("TLS", func(t *testing.T) { dial(t, grpc.WithTransportCredentials(tc.client)) })
+}
+
+// proxyV1Header builds a PROXY protocol v1 header line for a TCP4 flow.
+func proxyV1Header() []byte {
+	return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", "203.0.113.1", "203.0.113.2", 56324, 443))
+}
+
+// proxyV2Header builds a minimal PROXY protocol v2 header for a TCP4 flow.
+func proxyV2Header() []byte {
+	header := []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+	header = append(header, 0x21, 0x11) // version 2, PROXY command; AF_INET, STREAM
+	addr := make([]byte, 12)
+	copy(addr[0:4], net.ParseIP("203.0.113.1").To4())
+	copy(addr[4:8], net.ParseIP("203.0.113.2").To4())
+	binary.BigEndian.PutUint16(addr[8:10], 56324)
+	binary.BigEndian.PutUint16(addr[10:12], 443)
+	header = append(header, byte(len(addr)>>8), byte(len(addr)))
+	header = append(header, addr...)
+	return header
+}
+
+func dialerWithHeader(header []byte) grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(header); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	})
+}
+
+func TestProxyProtocol(t *testing.T) {
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	srv := createUnstartedServer(optionaltls.NewWithDetectors(tc.server, []optionaltls.ProtocolDetector{optionaltls.NewProxyProtocolDetector(), optionaltls.NewTLSDetector()}))
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	dial := func(t *testing.T, header []byte, dialOpt grpc.DialOption) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, dialerWithHeader(header), dialOpt)
+		if err != nil {
+			t.Fatalf("failed to connect to the server %v", err)
+		}
+		defer conn.Close()
+		c := pb.NewGreeterClient(conn)
+		if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"}); err != nil {
+			t.Fatalf("could not greet: %v", err)
+		}
+	}
+
+	t.Run("V1Plaintext", func(t *testing.T) {
+		dial(t, proxyV1Header(), grpc.WithInsecure())
+	})
+	t.Run("V1TLS", func(t *testing.T) {
+		dial(t, proxyV1Header(), grpc.WithTransportCredentials(tc.client))
+	})
+	t.Run("V2Plaintext", func(t *testing.T) {
+		dial(t, proxyV2Header(), grpc.WithInsecure())
+	})
+	t.Run("V2TLS", func(t *testing.T) {
+		dial(t, proxyV2Header(), grpc.WithTransportCredentials(tc.client))
+	})
+
+	t.Run("MalformedHeaderIsRejected", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("PROXY GARBAGE\r\n")); err != nil {
+			t.Fatalf("failed to write malformed header %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1)
+		if _, err := conn.Read(buf); err == nil {
+			t.Fatalf("expected the connection to be closed after a malformed PROXY header")
+		}
+	})
+
+	t.Run("ShortReadTimesOutWithoutPanicking", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("PROXY ")); err != nil {
+			t.Fatalf("failed to write partial header %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		buf := make([]byte, 1)
+		if _, err := conn.Read(buf); err == nil {
+			t.Fatalf("expected no response while the server is still waiting on a complete header")
+		}
+	})
+}
+
+func TestHTTP1Detector(t *testing.T) {
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	srv := createUnstartedServer(optionaltls.NewWithDetectors(tc.server, []optionaltls.ProtocolDetector{optionaltls.NewHTTP1Detector("use gRPC"), optionaltls.NewTLSDetector()}))
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	t.Run("RespondsWithUpgradeRequired", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+			t.Fatalf("failed to write request %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		statusLine, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response %v", err)
+		}
+		if !strings.Contains(statusLine, "426") {
+			t.Fatalf("expected a 426 status line, got %q", statusLine)
+		}
+	})
+
+	t.Run("GRPCStillWorks", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure())
+		if err != nil {
+			t.Fatalf("failed to connect to the server %v", err)
+		}
+		defer conn.Close()
+		c := pb.NewGreeterClient(conn)
+		if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"}); err != nil {
+			t.Fatalf("could not greet: %v", err)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+
+	"github.com/eselyavka/go-grpc-optionaltls-creds/optionaltls"
+)
+
+func TestOnHandshakeOutcomes(t *testing.T) {
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	var mu sync.Mutex
+	var outcomes []optionaltls.Outcome
+	onHandshake := func(remote net.Addr, outcome optionaltls.Outcome, err error) {
+		if remote == nil {
+			t.Errorf("expected a non-nil remote address for outcome %s", outcome)
+		}
+		mu.Lock()
+		outcomes = append(outcomes, outcome)
+		mu.Unlock()
+	}
+
+	var isActive bool
+	dynamicOptionF := optionaltls.DynamicOptionFunc(func() bool { return isActive })
+
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	srv := createUnstartedServer(optionaltls.NewWithDynamicOption(tc.server, dynamicOptionF, optionaltls.WithOnHandshake(onHandshake)))
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	dialTLS := func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(tc.client))
+		if err != nil {
+			t.Fatalf("failed to connect to the server %v", err)
+		}
+		defer conn.Close()
+		c := pb.NewGreeterClient(conn)
+		if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"}); err != nil {
+			t.Fatalf("could not greet: %v", err)
+		}
+	}
+
+	t.Run("TLS", func(t *testing.T) { dialTLS(t) })
+
+	t.Run("DynamicDisabled", func(t *testing.T) {
+		isActive = false
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte{0x00}); err != nil {
+			t.Fatalf("failed to write plaintext probe byte %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1)
+		if _, err := conn.Read(buf); err == nil {
+			t.Fatalf("expected the connection to be closed while plaintext is disabled")
+		}
+	})
+
+	t.Run("Plaintext", func(t *testing.T) {
+		isActive = true
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure())
+		if err != nil {
+			t.Fatalf("failed to connect to the server %v", err)
+		}
+		defer conn.Close()
+		c := pb.NewGreeterClient(conn)
+		if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"}); err != nil {
+			t.Fatalf("could not greet: %v", err)
+		}
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []optionaltls.Outcome{optionaltls.OutcomeTLS, optionaltls.OutcomeDynamicDisabled, optionaltls.OutcomePlaintext}
+	if len(outcomes) != len(want) {
+		t.Fatalf("got %d outcomes %v, want %v", len(outcomes), outcomes, want)
+	}
+	for i, o := range want {
+		if outcomes[i] != o {
+			t.Fatalf("outcome %d: got %s, want %s", i, outcomes[i], o)
+		}
+	}
+}
+
+func TestExpvarMetricsConcurrentAccepts(t *testing.T) {
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	metrics := optionaltls.NewExpvarMetrics("test_concurrent_accepts")
+
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	srv := createUnstartedServer(optionaltls.New(tc.server, optionaltls.WithMetrics(metrics)))
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	var failures int32
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			dialOpt := grpc.WithInsecure()
+			if i%2 == 0 {
+				dialOpt = grpc.WithTransportCredentials(tc.client)
+			}
+			conn, err := grpc.DialContext(ctx, addr, dialOpt)
+			if err != nil {
+				atomic.AddInt32(&failures, 1)
+				return
+			}
+			defer conn.Close()
+			c := pb.NewGreeterClient(conn)
+			if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"}); err != nil {
+				atomic.AddInt32(&failures, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failures != 0 {
+		t.Fatalf("%d of %d concurrent calls failed", failures, concurrency)
+	}
+	if got := metrics.Snapshot(); got.TLS+got.Plaintext != concurrency {
+		t.Fatalf("got %d TLS + %d plaintext handshakes, want %d total", got.TLS, got.Plaintext, concurrency)
+	}
+}
@@ -9,8 +9,11 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 
 	"github.com/eselyavka/go-grpc-optionaltls-creds/optionaltls"
 )
@@ -26,7 +29,11 @@ func (s *server) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloRe
 }
 
 func createUnstartedServer(creds credentials.TransportCredentials) *grpc.Server {
-	s := grpc.NewServer(grpc.Creds(creds))
+	return createUnstartedServerWithOpts(creds)
+}
+
+func createUnstartedServerWithOpts(creds credentials.TransportCredentials, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append([]grpc.ServerOption{grpc.Creds(creds)}, opts...)...)
 	pb.RegisterGreeterServer(s, &server{})
 	return s
 }
@@ -170,3 +177,65 @@ func TestDynamicOption(t *testing.T) {
 		}
 	}
 }
+
+// requireTLSInterceptor rejects any RPC whose connection did not negotiate
+// TLS, demonstrating that optionaltls.AuthInfo can be used with
+// credentials.CheckSecurityLevel to enforce TLS on a per-method basis.
+func requireTLSInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "rpc %s requires TLS: no peer information", info.FullMethod)
+	}
+	if err := credentials.CheckSecurityLevel(p.AuthInfo, credentials.PrivacyAndIntegrity); err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "rpc %s requires TLS: %v", info.FullMethod, err)
+	}
+	return handler(ctx, req)
+}
+
+func TestSecurityLevel(t *testing.T) {
+	testCtx, testCancel := context.WithCancel(context.Background())
+	defer testCancel()
+
+	tc, err := createCredentials()
+	if err != nil {
+		t.Fatalf("failed to create credentials %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	srv := createUnstartedServerWithOpts(optionaltls.New(tc.server), grpc.UnaryInterceptor(requireTLSInterceptor))
+	go func() {
+		srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	dial := func(t *testing.T, dialOpt grpc.DialOption) error {
+		ctx, cancel := context.WithTimeout(testCtx, 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, dialOpt)
+		if err != nil {
+			t.Fatalf("failed to connect to the server %v", err)
+		}
+		defer conn.Close()
+		c := pb.NewGreeterClient(conn)
+		_, err = c.SayHello(ctx, &pb.HelloRequest{Name: "noxiouz"})
+		return err
+	}
+
+	t.Run("PlaintextRejected", func(t *testing.T) {
+		err := dial(t, grpc.WithInsecure())
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected PermissionDenied for a plaintext connection, got %v", err)
+		}
+	})
+	t.Run("TLSAllowed", func(t *testing.T) {
+		if err := dial(t, grpc.WithTransportCredentials(tc.client)); err != nil {
+			t.Fatalf("expected TLS connection to satisfy the security level, got %v", err)
+		}
+	})
+}